@@ -0,0 +1,44 @@
+// Package config loads the probe module configuration file used by the
+// multi-target /probe endpoint.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/camptocamp/prometheus-puppetdb-exporter/internal/exporter/customqueries"
+)
+
+// Module describes a named set of PuppetDB connection settings that can be
+// selected per-target via the /probe endpoint's `module` query parameter.
+type Module struct {
+	CACertFile     string `yaml:"ca_file"`
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file"`
+	SSLSkipVerify  bool   `yaml:"ssl_skip_verify"`
+	Categories     string `yaml:"categories"`
+	UnreportedNode string `yaml:"unreported_node"`
+}
+
+// Config is the top-level shape of the --config.file document.
+type Config struct {
+	Modules map[string]Module           `yaml:"modules"`
+	Queries []customqueries.QueryConfig `yaml:"queries"`
+}
+
+// Load reads and parses the probe module configuration at path.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %s", err)
+	}
+
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %s", err)
+	}
+
+	return &c, nil
+}
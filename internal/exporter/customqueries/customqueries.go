@@ -0,0 +1,190 @@
+// Package customqueries turns operator-defined PQL queries into Prometheus
+// metrics, so new report dimensions can be added through configuration
+// instead of forking the exporter.
+package customqueries
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// QueryConfig declares a single custom PQL query and how its result rows map
+// onto a Prometheus metric.
+type QueryConfig struct {
+	Name         string   `yaml:"name"`
+	Help         string   `yaml:"help"`
+	PQL          string   `yaml:"pql"`
+	ValueColumn  string   `yaml:"value_column"`
+	LabelColumns []string `yaml:"label_columns"`
+	Type         string   `yaml:"type"`
+	Interval     string   `yaml:"interval"`
+}
+
+// Querier is the subset of the PuppetDB client needed to run custom queries.
+type Querier interface {
+	Query(pql string, out interface{}) error
+}
+
+// query holds the running state for one configured QueryConfig.
+type query struct {
+	cfg       QueryConfig
+	interval  time.Duration
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+
+	mu   sync.RWMutex
+	rows []map[string]interface{}
+	err  error
+}
+
+// Collector runs every configured query on its own interval and exposes the
+// result of its latest run as Prometheus metrics.
+type Collector struct {
+	client  Querier
+	logger  *slog.Logger
+	queries []*query
+	stopCh  chan struct{}
+}
+
+// NewCollector validates configs and returns a Collector ready to be
+// started with Run.
+func NewCollector(client Querier, configs []QueryConfig, logger *slog.Logger) (*Collector, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	c := &Collector{
+		client: client,
+		logger: logger,
+		stopCh: make(chan struct{}),
+	}
+
+	for _, cfg := range configs {
+		interval, err := time.ParseDuration(cfg.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("query %q: invalid interval: %s", cfg.Name, err)
+		}
+
+		var valueType prometheus.ValueType
+		switch cfg.Type {
+		case "", "gauge":
+			valueType = prometheus.GaugeValue
+		case "counter":
+			valueType = prometheus.CounterValue
+		default:
+			return nil, fmt.Errorf("query %q: unsupported type %q", cfg.Name, cfg.Type)
+		}
+
+		c.queries = append(c.queries, &query{
+			cfg:      cfg,
+			interval: interval,
+			desc: prometheus.NewDesc(
+				cfg.Name,
+				cfg.Help,
+				cfg.LabelColumns,
+				nil,
+			),
+			valueType: valueType,
+		})
+	}
+
+	return c, nil
+}
+
+// Run starts one goroutine per configured query, each polling PuppetDB on
+// its own interval until Stop is called.
+func (c *Collector) Run() {
+	for _, q := range c.queries {
+		go c.runQuery(q)
+	}
+}
+
+// Stop terminates all running query goroutines.
+func (c *Collector) Stop() {
+	close(c.stopCh)
+}
+
+func (c *Collector) runQuery(q *query) {
+	c.refresh(q)
+
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.refresh(q)
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+func (c *Collector) refresh(q *query) {
+	var rows []map[string]interface{}
+	err := c.client.Query(q.cfg.PQL, &rows)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err != nil {
+		c.logger.Error("custom query failed", "query", q.cfg.Name, "error", err)
+		q.err = err
+		return
+	}
+
+	q.rows = rows
+	q.err = nil
+}
+
+// Describe outputs the metric descriptions for every configured query.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, q := range c.queries {
+		ch <- q.desc
+	}
+}
+
+// Collect emits the latest fetched result for every configured query. When
+// the latest run of a query failed, it emits an invalid metric carrying that
+// error instead of the rows from an earlier successful run.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, q := range c.queries {
+		q.mu.RLock()
+		rows, err := q.rows, q.err
+		q.mu.RUnlock()
+
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(q.desc, err)
+			continue
+		}
+
+		for _, row := range rows {
+			value, ok := toFloat64(row[q.cfg.ValueColumn])
+			if !ok {
+				continue
+			}
+
+			labelValues := make([]string, len(q.cfg.LabelColumns))
+			for i, label := range q.cfg.LabelColumns {
+				labelValues[i] = fmt.Sprintf("%v", row[label])
+			}
+
+			ch <- prometheus.MustNewConstMetric(q.desc, q.valueType, value, labelValues...)
+		}
+	}
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
@@ -2,15 +2,21 @@ package exporter
 
 import (
 	"fmt"
+	"log/slog"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	log "github.com/sirupsen/logrus"
 
+	"github.com/camptocamp/prometheus-puppetdb-exporter/internal/exporter/customqueries"
 	"github.com/camptocamp/prometheus-puppetdb-exporter/internal/puppetdb"
 )
 
+// defaultReportCacheSize caps the number of distinct report hashes kept in
+// the report metrics cache. The cap is not configurable.
+const defaultReportCacheSize = 10000
+
 // ExporterConfig defines the config for Exporter
 type Config struct {
 	URL        string
@@ -21,6 +27,25 @@ type Config struct {
 
 	Categories         map[string]struct{}
 	UnreportedDuration time.Duration
+
+	// Workers bounds the number of concurrent ReportMetrics lookups issued
+	// against PuppetDB during a single Collect call.
+	Workers int
+	// ReportCacheTTL controls how long a report's metrics are memoized
+	// before being re-fetched from PuppetDB.
+	ReportCacheTTL time.Duration
+
+	// Queries declares operator-defined PQL queries to expose as custom metrics.
+	Queries []customqueries.QueryConfig
+
+	// FactLabels lists the PuppetDB facts to join onto node series as labels.
+	FactLabels []string
+	// FactGauges lists the PuppetDB facts to expose as puppet_node_fact
+	// gauges instead of labels.
+	FactGauges []string
+
+	// Logger receives structured exporter logs. Defaults to slog.Default() if nil.
+	Logger *slog.Logger
 }
 
 // Exporter implements the prometheus.Exporter interface, and exports PuppetDB metrics
@@ -30,6 +55,20 @@ type Exporter struct {
 	metrics            map[string]*prometheus.Desc
 	categories         map[string]struct{}
 	unreportedDuration time.Duration
+	logger             *slog.Logger
+
+	workers     int
+	reportCache *reportCache
+
+	customQueries *customqueries.Collector
+
+	factLabels []string
+	factGauges []string
+	// factNames is the deduplicated union of factLabels and factGauges,
+	// i.e. every fact that needs to be fetched from PuppetDB.
+	factNames []string
+
+	factsCache *factsCache
 }
 
 var (
@@ -40,10 +79,27 @@ var (
 
 // NewPuppetDBExporter returns a new exporter of PuppetDB metrics.
 func NewPuppetDBExporter(c *Config, r *prometheus.Registry) (e *Exporter, err error) {
+	workers := c.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	logger := c.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
 	e = &Exporter{
 		namespace:          "puppetdb",
 		categories:         c.Categories,
 		unreportedDuration: c.UnreportedDuration,
+		logger:             logger,
+		workers:            workers,
+		reportCache:        newReportCache(c.ReportCacheTTL, defaultReportCacheSize),
+		factLabels:         c.FactLabels,
+		factGauges:         c.FactGauges,
+		factNames:          dedupFactNames(c.FactLabels, c.FactGauges),
+		factsCache:         newFactsCache(c.ReportCacheTTL),
 	}
 
 	opts := &puppetdb.Options{
@@ -56,8 +112,15 @@ func NewPuppetDBExporter(c *Config, r *prometheus.Registry) (e *Exporter, err er
 
 	e.client, err = puppetdb.NewClient(opts)
 	if err != nil {
-		log.Fatalf("failed to create new client: %s", err)
-		return
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+
+	if len(c.Queries) > 0 {
+		e.customQueries, err = customqueries.NewCollector(e.client, c.Queries, logger)
+		if err != nil {
+			return nil, err
+		}
+		e.customQueries.Run()
 	}
 
 	e.initGauges(c.Categories)
@@ -66,11 +129,25 @@ func NewPuppetDBExporter(c *Config, r *prometheus.Registry) (e *Exporter, err er
 	return
 }
 
+// Close stops any background work the exporter started, such as custom
+// query collectors, so a discarded Exporter doesn't keep polling PuppetDB.
+// Callers that build short-lived exporters (e.g. the /probe endpoint) must
+// call Close once an exporter is no longer needed.
+func (e *Exporter) Close() {
+	if e.customQueries != nil {
+		e.customQueries.Stop()
+	}
+}
+
 // Describe outputs PuppetDB metric descriptions
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	for _, m := range e.metrics {
 		ch <- m
 	}
+
+	if e.customQueries != nil {
+		e.customQueries.Describe(ch)
+	}
 }
 
 // Collect fetches new metrics from the PuppetDB and updates the appropriate metrics
@@ -87,6 +164,9 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 		return
 	}
 
+	reportMetricsByHash := e.fetchReportMetrics(nodes)
+	factsByNode := e.fetchFacts()
+
 	for _, node := range nodes {
 		var deactivated string
 		if node.Deactivated == "" {
@@ -101,14 +181,17 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 		}
 		latestReport, err := time.Parse("2006-01-02T15:04:05Z", node.ReportTimestamp)
 		if err != nil {
-			log.Errorf("failed to parse report timestamp: %s", err)
+			e.logger.Error("failed to parse report timestamp", "certname", node.Certname, "error", err)
 			continue
 		}
 
+		nodeFacts := factsByNode[node.Certname]
+		factLabels := e.factLabelValues(nodeFacts)
+
 		ch <- prometheus.MustNewConstMetric(
 			e.metrics["report"], prometheus.GaugeValue,
 			float64(latestReport.Unix()),
-			node.ReportEnvironment, node.Certname, deactivated)
+			append([]string{node.ReportEnvironment, node.Certname, deactivated}, factLabels...)...)
 
 		if latestReport.Add(e.unreportedDuration).Before(time.Now()) {
 			statuses["unreported"]++
@@ -124,11 +207,19 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(
 			e.metrics["node_last_report_status"], prometheus.GaugeValue,
 			1,
-			lastReportStatus, node.Certname,
+			append([]string{lastReportStatus, node.Certname}, factLabels...)...,
 		)
 
+		for _, name := range e.factGauges {
+			if value, ok := numericFactValue(nodeFacts[name]); ok {
+				ch <- prometheus.MustNewConstMetric(
+					e.metrics["node_fact"], prometheus.GaugeValue,
+					value, name, node.Certname)
+			}
+		}
+
 		if node.LatestReportHash != "" {
-			reportMetrics, _ := e.client.ReportMetrics(node.LatestReportHash)
+			reportMetrics := reportMetricsByHash[node.LatestReportHash]
 			for _, reportMetric := range reportMetrics {
 				_, ok := e.categories[reportMetric.Category]
 				if ok {
@@ -151,10 +242,140 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 			statusName)
 	}
 
+	hits, misses, size := e.reportCache.stats()
+	ch <- prometheus.MustNewConstMetric(
+		e.metrics["report_cache_hits"], prometheus.CounterValue, float64(hits))
+	ch <- prometheus.MustNewConstMetric(
+		e.metrics["report_cache_misses"], prometheus.CounterValue, float64(misses))
+	ch <- prometheus.MustNewConstMetric(
+		e.metrics["report_cache_size"], prometheus.GaugeValue, float64(size))
+
 	duration := 1000000 * time.Now().Sub(collectStart).Nanoseconds()
 	ch <- prometheus.MustNewConstMetric(
 		e.metrics["puppetdb_exporter_collect_duration"], prometheus.GaugeValue,
 		float64(duration))
+
+	if e.customQueries != nil {
+		e.customQueries.Collect(ch)
+	}
+}
+
+// fetchReportMetrics resolves report metrics for every distinct
+// LatestReportHash across nodes, fanning the PuppetDB lookups out over a
+// bounded worker pool and serving cached results when available.
+func (e *Exporter) fetchReportMetrics(nodes []puppetdb.Node) map[string][]puppetdb.ReportMetric {
+	hashes := make(map[string]struct{})
+	for _, node := range nodes {
+		if node.LatestReportHash != "" {
+			hashes[node.LatestReportHash] = struct{}{}
+		}
+	}
+
+	results := make(map[string][]puppetdb.ReportMetric, len(hashes))
+
+	jobs := make(chan string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < e.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for hash := range jobs {
+				metrics := e.reportMetrics(hash)
+				mu.Lock()
+				results[hash] = metrics
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for hash := range hashes {
+		jobs <- hash
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// reportMetrics returns the metrics for a report hash, serving from the
+// cache when possible and falling back to PuppetDB on a miss.
+func (e *Exporter) reportMetrics(hash string) []puppetdb.ReportMetric {
+	if metrics, ok := e.reportCache.get(hash); ok {
+		return metrics
+	}
+
+	metrics, err := e.client.ReportMetrics(hash)
+	if err != nil {
+		e.logger.Error("failed to fetch report metrics", "report_hash", hash, "error", err)
+		return nil
+	}
+
+	e.reportCache.set(hash, metrics)
+	return metrics
+}
+
+// fetchFacts returns the configured facts for every node, keyed by
+// certname, serving from the cache when possible.
+func (e *Exporter) fetchFacts() map[string]map[string]interface{} {
+	if len(e.factNames) == 0 {
+		return nil
+	}
+
+	if byNode, ok := e.factsCache.get(); ok {
+		return byNode
+	}
+
+	facts, err := e.client.Facts(e.factNames)
+	if err != nil {
+		e.logger.Error("failed to fetch facts", "error", err)
+		return nil
+	}
+
+	byNode := make(map[string]map[string]interface{})
+	for _, f := range facts {
+		if byNode[f.Certname] == nil {
+			byNode[f.Certname] = make(map[string]interface{})
+		}
+		byNode[f.Certname][f.Name] = f.Value
+	}
+
+	e.factsCache.set(byNode)
+	return byNode
+}
+
+// factLabelValues returns the fact label values for a node, in the order
+// e.factLabels was declared.
+func (e *Exporter) factLabelValues(nodeFacts map[string]interface{}) []string {
+	values := make([]string, len(e.factLabels))
+	for i, name := range e.factLabels {
+		if v, ok := nodeFacts[name]; ok {
+			values[i] = fmt.Sprintf("%v", v)
+		}
+	}
+	return values
+}
+
+// dedupFactNames returns the deduplicated union of labels and gauges, the
+// full set of facts that need to be fetched from PuppetDB.
+func dedupFactNames(labels, gauges []string) []string {
+	seen := make(map[string]struct{}, len(labels)+len(gauges))
+	var names []string
+	for _, name := range append(append([]string{}, labels...), gauges...) {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		names = append(names, name)
+	}
+	return names
+}
+
+// numericFactValue returns v as a float64 if it's a numeric fact value.
+func numericFactValue(v interface{}) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
 }
 
 func (e *Exporter) initGauges(categories map[string]struct{}) {
@@ -163,7 +384,7 @@ func (e *Exporter) initGauges(categories map[string]struct{}) {
 	e.metrics["node_last_report_status"] = prometheus.NewDesc(
 		e.namespace+"_node_last_report_status",
 		"Last report status for a node by type",
-		[]string{"status", "host"},
+		append([]string{"status", "host"}, e.factLabels...),
 		nil,
 	)
 
@@ -186,7 +407,13 @@ func (e *Exporter) initGauges(categories map[string]struct{}) {
 	e.metrics["report"] = prometheus.NewDesc(
 		"puppet_report",
 		"Timestamp of latest report",
-		[]string{"environment", "host", "deactivated"},
+		append([]string{"environment", "host", "deactivated"}, e.factLabels...),
+		nil)
+
+	e.metrics["node_fact"] = prometheus.NewDesc(
+		"puppet_node_fact",
+		"Value of a numeric PuppetDB fact",
+		[]string{"name", "host"},
 		nil)
 
 	e.metrics["puppetdb_exporter_collect_duration"] = prometheus.NewDesc(
@@ -195,4 +422,22 @@ func (e *Exporter) initGauges(categories map[string]struct{}) {
 		[]string{},
 		nil)
 
+	e.metrics["report_cache_hits"] = prometheus.NewDesc(
+		"puppetdb_exporter_report_cache_hits_total",
+		"Total number of report metrics cache hits",
+		[]string{},
+		nil)
+
+	e.metrics["report_cache_misses"] = prometheus.NewDesc(
+		"puppetdb_exporter_report_cache_misses_total",
+		"Total number of report metrics cache misses",
+		[]string{},
+		nil)
+
+	e.metrics["report_cache_size"] = prometheus.NewDesc(
+		"puppetdb_exporter_report_cache_size",
+		"Current number of entries in the report metrics cache",
+		[]string{},
+		nil)
+
 }
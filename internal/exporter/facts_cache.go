@@ -0,0 +1,40 @@
+package exporter
+
+import (
+	"sync"
+	"time"
+)
+
+// factsCache memoizes the batched per-node fact lookup used to annotate
+// report series with fact labels. Unlike reportCache, there is only ever
+// one entry: all configured facts for all nodes, fetched in a single call.
+type factsCache struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	expiry time.Time
+	byNode map[string]map[string]interface{}
+}
+
+func newFactsCache(ttl time.Duration) *factsCache {
+	return &factsCache{ttl: ttl}
+}
+
+// get returns the cached facts, if they haven't expired yet.
+func (c *factsCache) get() (map[string]map[string]interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.byNode == nil || time.Now().After(c.expiry) {
+		return nil, false
+	}
+	return c.byNode, true
+}
+
+// set stores freshly fetched facts and resets the TTL.
+func (c *factsCache) set(byNode map[string]map[string]interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.byNode = byNode
+	c.expiry = time.Now().Add(c.ttl)
+}
@@ -0,0 +1,101 @@
+package exporter
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/camptocamp/prometheus-puppetdb-exporter/internal/puppetdb"
+)
+
+// reportCacheEntry holds a cached ReportMetrics result, keyed by report hash.
+type reportCacheEntry struct {
+	hash    string
+	metrics []puppetdb.ReportMetric
+	expiry  time.Time
+}
+
+// reportCache memoizes ReportMetrics lookups by report hash. Entries are
+// evicted once their TTL expires, and the total number of entries is capped
+// with an LRU policy so memory doesn't grow unbounded on fleets with a high
+// rate of report churn.
+type reportCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	entries map[string]*list.Element
+	order   *list.List // front = most recently used
+
+	hits   uint64
+	misses uint64
+}
+
+func newReportCache(ttl time.Duration, maxSize int) *reportCache {
+	return &reportCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the cached metrics for hash, if present and not expired.
+func (c *reportCache) get(hash string) ([]puppetdb.ReportMetric, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[hash]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	entry := elem.Value.(*reportCacheEntry)
+	if time.Now().After(entry.expiry) {
+		c.order.Remove(elem)
+		delete(c.entries, hash)
+		c.misses++
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	c.hits++
+	return entry.metrics, true
+}
+
+// set stores metrics for hash, evicting the least recently used entry if the
+// cache has grown past maxSize.
+func (c *reportCache) set(hash string, metrics []puppetdb.ReportMetric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[hash]; ok {
+		elem.Value.(*reportCacheEntry).metrics = metrics
+		elem.Value.(*reportCacheEntry).expiry = time.Now().Add(c.ttl)
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	entry := &reportCacheEntry{
+		hash:    hash,
+		metrics: metrics,
+		expiry:  time.Now().Add(c.ttl),
+	}
+	elem := c.order.PushFront(entry)
+	c.entries[hash] = elem
+
+	if c.maxSize > 0 && c.order.Len() > c.maxSize {
+		oldest := c.order.Back()
+		if oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*reportCacheEntry).hash)
+		}
+	}
+}
+
+// stats returns a snapshot of hits, misses and current size.
+func (c *reportCache) stats() (hits, misses uint64, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses, c.order.Len()
+}
@@ -0,0 +1,135 @@
+package puppetdb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Options configures a PuppetDB client.
+type Options struct {
+	URL        string
+	CertPath   string
+	CACertPath string
+	KeyPath    string
+	SSLVerify  bool
+}
+
+// PuppetDB is a client for the PuppetDB HTTP API.
+type PuppetDB struct {
+	url        string
+	httpClient *http.Client
+}
+
+// Node represents a PuppetDB node as returned by the /pdb/query/v4/nodes endpoint.
+type Node struct {
+	Certname           string `json:"certname"`
+	Deactivated        string `json:"deactivated"`
+	ReportEnvironment  string `json:"report_environment"`
+	ReportTimestamp    string `json:"report_timestamp"`
+	LatestReportHash   string `json:"latest_report_hash"`
+	LatestReportStatus string `json:"latest_report_status"`
+}
+
+// ReportMetric represents a single metric entry from a PuppetDB report.
+type ReportMetric struct {
+	Category string  `json:"category"`
+	Name     string  `json:"name"`
+	Value    float64 `json:"value"`
+}
+
+// NewClient returns a new PuppetDB client configured from opts.
+func NewClient(opts *Options) (*PuppetDB, error) {
+	transport := &http.Transport{}
+
+	if opts.CertPath != "" && opts.KeyPath != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertPath, opts.KeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %s", err)
+		}
+
+		tlsConfig := &tls.Config{
+			Certificates:       []tls.Certificate{cert},
+			InsecureSkipVerify: !opts.SSLVerify,
+		}
+
+		if opts.CACertPath != "" {
+			caCert, err := ioutil.ReadFile(opts.CACertPath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA certificate: %s", err)
+			}
+
+			caCertPool := x509.NewCertPool()
+			caCertPool.AppendCertsFromPEM(caCert)
+			tlsConfig.RootCAs = caCertPool
+		}
+
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &PuppetDB{
+		url:        opts.URL,
+		httpClient: &http.Client{Transport: transport},
+	}, nil
+}
+
+func (p *PuppetDB) get(path string, out interface{}) error {
+	resp, err := p.httpClient.Get(p.url + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, path)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Nodes returns the list of nodes known to PuppetDB.
+func (p *PuppetDB) Nodes() ([]Node, error) {
+	var nodes []Node
+	err := p.get("/pdb/query/v4/nodes", &nodes)
+	return nodes, err
+}
+
+// ReportMetrics returns the metrics recorded against the report identified by hash.
+func (p *PuppetDB) ReportMetrics(hash string) ([]ReportMetric, error) {
+	var metrics []ReportMetric
+	err := p.get(fmt.Sprintf("/pdb/query/v4/reports/%s/metrics", hash), &metrics)
+	return metrics, err
+}
+
+// Query runs an arbitrary PQL query against the /pdb/query/v4 endpoint and
+// decodes the resulting JSON rows into out, e.g. a *[]map[string]interface{}.
+func (p *PuppetDB) Query(pql string, out interface{}) error {
+	return p.get("/pdb/query/v4?query="+url.QueryEscape(pql), out)
+}
+
+// Fact represents a single fact value for a node, as returned by the
+// /pdb/query/v4/facts endpoint.
+type Fact struct {
+	Certname string      `json:"certname"`
+	Name     string      `json:"name"`
+	Value    interface{} `json:"value"`
+}
+
+// Facts returns the named facts for every node in a single batched query,
+// rather than one request per node.
+func (p *PuppetDB) Facts(names []string) ([]Fact, error) {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = fmt.Sprintf("%q", name)
+	}
+	pql := fmt.Sprintf("facts[certname, name, value] { name in [%s] }", strings.Join(quoted, ", "))
+
+	var facts []Fact
+	err := p.Query(pql, &facts)
+	return facts, err
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"log/slog"
 	"net/http"
 	"os"
 	"runtime"
@@ -10,24 +11,34 @@ import (
 	"github.com/jessevdk/go-flags"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	log "github.com/sirupsen/logrus"
+	"github.com/prometheus/exporter-toolkit/web"
 
+	"github.com/camptocamp/prometheus-puppetdb-exporter/internal/config"
 	"github.com/camptocamp/prometheus-puppetdb-exporter/internal/exporter"
+	"github.com/camptocamp/prometheus-puppetdb-exporter/internal/exporter/customqueries"
 )
 
 // Config stores handler's configuration
 type Config struct {
-	Version        bool   `long:"version" description:"Show version."`
-	PuppetDBUrl    string `short:"u" long:"puppetdb-url" description:"PuppetDB base URL." env:"PUPPETDB_URL" required:"true"`
-	CertFile       string `long:"cert-file" description:"A PEM encoded certificate file." env:"PUPPETDB_CERT_FILE"`
-	KeyFile        string `long:"key-file" description:"A PEM encoded private key file." env:"PUPPETDB_KEY_FILE"`
-	CACertFile     string `long:"ca-file" description:"A PEM encoded CA's certificate." env:"PUPPETDB_CA_FILE"`
-	SSLSkipVerify  bool   `long:"ssl-skip-verify" description:"Skip SSL verification." env:"PUPPETDB_SSL_SKIP_VERIFY"`
-	ListenAddress  string `long:"listen-address" description:"Address to listen on for web interface and telemetry." env:"PUPPETDB_LISTEN_ADDRESS" default:"0.0.0.0:9121"`
-	MetricPath     string `long:"metric-path" description:"Path under which to expose metrics." env:"PUPPETDB_METRIC_PATH" default:"/metrics"`
-	Verbose        bool   `long:"verbose" description:"Enable debug mode" env:"PUPPETDB_VERBOSE"`
-	UnreportedNode string `long:"unreported-node" description:"Tag nodes as unreported if the latest report is older than the defined duration." env:"PUPPETDB_UNREPORTED_NODE" default:"2h"`
-	Categories     string `long:"categories" description:"Report metrics categories to scrape." env:"REPORT_METRICS_CATEGORIES" default:"resources,time,changes,events"`
+	Version          bool   `long:"version" description:"Show version."`
+	PuppetDBUrl      string `short:"u" long:"puppetdb-url" description:"PuppetDB base URL." env:"PUPPETDB_URL" required:"true"`
+	CertFile         string `long:"cert-file" description:"A PEM encoded certificate file." env:"PUPPETDB_CERT_FILE"`
+	KeyFile          string `long:"key-file" description:"A PEM encoded private key file." env:"PUPPETDB_KEY_FILE"`
+	CACertFile       string `long:"ca-file" description:"A PEM encoded CA's certificate." env:"PUPPETDB_CA_FILE"`
+	SSLSkipVerify    bool   `long:"ssl-skip-verify" description:"Skip SSL verification." env:"PUPPETDB_SSL_SKIP_VERIFY"`
+	ListenAddress    string `long:"listen-address" description:"Address to listen on for web interface and telemetry." env:"PUPPETDB_LISTEN_ADDRESS" default:"0.0.0.0:9121"`
+	MetricPath       string `long:"metric-path" description:"Path under which to expose metrics." env:"PUPPETDB_METRIC_PATH" default:"/metrics"`
+	LogFormat        string `long:"log.format" description:"Output format of log messages." choice:"logfmt" choice:"json" env:"PUPPETDB_LOG_FORMAT" default:"logfmt"`
+	LogLevel         string `long:"log.level" description:"Only log messages with the given severity or above." choice:"debug" choice:"info" choice:"warn" choice:"error" env:"PUPPETDB_LOG_LEVEL" default:"info"`
+	UnreportedNode   string `long:"unreported-node" description:"Tag nodes as unreported if the latest report is older than the defined duration." env:"PUPPETDB_UNREPORTED_NODE" default:"2h"`
+	Categories       string `long:"categories" description:"Report metrics categories to scrape." env:"REPORT_METRICS_CATEGORIES" default:"resources,time,changes,events"`
+	Workers          int    `long:"workers" description:"Number of concurrent workers used to fetch report metrics from PuppetDB." env:"PUPPETDB_WORKERS" default:"10"`
+	ReportCacheTTL   string `long:"report-cache-ttl" description:"How long to cache a report's metrics, keyed by report hash, before re-fetching them." env:"PUPPETDB_REPORT_CACHE_TTL" default:"5m"`
+	ConfigFile       string `long:"config.file" description:"Path to a YAML file defining named modules for the /probe endpoint." env:"PUPPETDB_CONFIG_FILE"`
+	Facts            string `long:"facts" description:"Comma-separated list of string-valued PuppetDB facts to join onto node series as labels." env:"PUPPETDB_FACTS"`
+	FactGauges       string `long:"fact-gauges" description:"Comma-separated list of numeric-valued PuppetDB facts to expose as puppet_node_fact gauges." env:"PUPPETDB_FACT_GAUGES"`
+	WebConfigFile    string `long:"web.config.file" description:"Path to a file enabling TLS and/or basic auth on the web server, in exporter-toolkit's web config format." env:"PUPPETDB_WEB_CONFIG_FILE"`
+	WebSystemdSocket bool   `long:"web.systemd-socket" description:"Use systemd socket activation listeners instead of port listeners (Linux only)." env:"PUPPETDB_WEB_SYSTEMD_SOCKET"`
 }
 
 var (
@@ -48,16 +59,11 @@ func main() {
 		}
 	}
 
-	log.Printf("PuppetDB Metrics Exporter %s    build date: %s    sha1: %s    Go: %s",
-		version, buildDate, commitSha1,
-		runtime.Version(),
-	)
-	if c.Verbose {
-		log.SetLevel(log.DebugLevel)
-		log.Debugln("Enabling debug output")
-	} else {
-		log.SetLevel(log.InfoLevel)
-	}
+	logger := newLogger(c.LogFormat, c.LogLevel)
+	slog.SetDefault(logger)
+
+	logger.Info("starting PuppetDB Metrics Exporter",
+		"version", version, "build_date", buildDate, "commit_sha", commitSha1, "go_version", runtime.Version())
 
 	if c.Version {
 		return
@@ -65,15 +71,26 @@ func main() {
 
 	unreportedDuration, err := time.ParseDuration(c.UnreportedNode)
 	if err != nil {
-		log.Fatalf("failed to parse unreported duration: %s", err)
+		logger.Error("failed to parse unreported duration", "error", err)
+		os.Exit(1)
 	}
 
-	// Create a map[string]struct{} of categories to provide an efficient way to
-	// find if a category exists in the list of categories.
-	cats := strings.Split(c.Categories, ",")
-	categories := make(map[string]struct{}, len(cats))
-	for _, category := range cats {
-		categories[category] = struct{}{}
+	reportCacheTTL, err := time.ParseDuration(c.ReportCacheTTL)
+	if err != nil {
+		logger.Error("failed to parse report cache TTL", "error", err)
+		os.Exit(1)
+	}
+
+	var modules map[string]config.Module
+	var queries []customqueries.QueryConfig
+	if c.ConfigFile != "" {
+		cfg, err := config.Load(c.ConfigFile)
+		if err != nil {
+			logger.Error("failed to load config file", "error", err)
+			os.Exit(1)
+		}
+		modules = cfg.Modules
+		queries = cfg.Queries
 	}
 
 	registry := prometheus.NewPedanticRegistry()
@@ -83,11 +100,18 @@ func main() {
 		CACertPath:         c.CACertFile,
 		KeyPath:            c.KeyFile,
 		SSLVerify:          !c.SSLSkipVerify,
-		Categories:         categories,
+		Categories:         parseCategories(c.Categories),
 		UnreportedDuration: unreportedDuration,
+		Workers:            c.Workers,
+		ReportCacheTTL:     reportCacheTTL,
+		Queries:            queries,
+		FactLabels:         parseFacts(c.Facts),
+		FactGauges:         parseFacts(c.FactGauges),
+		Logger:             logger,
 	}, registry)
 	if err != nil {
-		log.Fatalf("failed to initialize exporter: %s", err)
+		logger.Error("failed to initialize exporter", "error", err)
+		os.Exit(1)
 	}
 
 	buildInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
@@ -98,6 +122,7 @@ func main() {
 	registry.MustRegister(buildInfo)
 
 	http.Handle(c.MetricPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	http.HandleFunc("/probe", newProbeHandler(c, modules, queries, newTargetCache(), logger))
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`
 <html>
@@ -110,6 +135,63 @@ func main() {
 						`))
 	})
 
-	log.Infof("Providing metrics at %s%s", c.ListenAddress, c.MetricPath)
-	log.Fatal(http.ListenAndServe(c.ListenAddress, nil))
+	logger.Info("providing metrics", "address", c.ListenAddress, "path", c.MetricPath)
+
+	srv := &http.Server{}
+	flagsConfig := &web.FlagConfig{
+		WebListenAddresses: &[]string{c.ListenAddress},
+		WebSystemdSocket:   &c.WebSystemdSocket,
+		WebConfigFile:      &c.WebConfigFile,
+	}
+	if err := web.ListenAndServe(srv, flagsConfig, logger); err != nil {
+		logger.Error("server exited", "error", err)
+		os.Exit(1)
+	}
+}
+
+// newLogger builds the process-wide slog.Logger from the --log.format and
+// --log.level flags.
+func newLogger(format, level string) *slog.Logger {
+	var levelVar slog.Level
+	switch level {
+	case "debug":
+		levelVar = slog.LevelDebug
+	case "warn":
+		levelVar = slog.LevelWarn
+	case "error":
+		levelVar = slog.LevelError
+	default:
+		levelVar = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{Level: levelVar}
+
+	var handler slog.Handler
+	if format == "json" {
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	}
+
+	return slog.New(handler)
+}
+
+// parseCategories turns a comma-separated list of report metrics categories
+// into a map[string]struct{} for efficient membership checks.
+func parseCategories(categories string) map[string]struct{} {
+	cats := strings.Split(categories, ",")
+	out := make(map[string]struct{}, len(cats))
+	for _, category := range cats {
+		out[category] = struct{}{}
+	}
+	return out
+}
+
+// parseFacts turns a comma-separated list of fact names into a slice,
+// returning nil for an empty list so the fact subsystem stays disabled.
+func parseFacts(facts string) []string {
+	if facts == "" {
+		return nil
+	}
+	return strings.Split(facts, ",")
 }
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/camptocamp/prometheus-puppetdb-exporter/internal/config"
+	"github.com/camptocamp/prometheus-puppetdb-exporter/internal/exporter"
+	"github.com/camptocamp/prometheus-puppetdb-exporter/internal/exporter/customqueries"
+)
+
+// maxProbeTargets bounds the number of per-target exporters kept alive
+// between probes, evicting the least recently used target once exceeded.
+const maxProbeTargets = 100
+
+// probeTarget is a cached, already-initialized exporter for a single
+// target+module pair, along with the registry it is bound to.
+type probeTarget struct {
+	key      string
+	exporter *exporter.Exporter
+	registry *prometheus.Registry
+}
+
+// targetCache memoizes per-target exporters so repeated probes of the same
+// target don't pay mTLS setup cost on every scrape.
+type targetCache struct {
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	order    *list.List
+	inflight map[string]*buildCall
+}
+
+// buildCall tracks a build in progress for a key, so concurrent first
+// probes of the same target wait for and share a single result instead of
+// each building (and leaking) their own exporter.
+type buildCall struct {
+	wg  sync.WaitGroup
+	pt  *probeTarget
+	err error
+}
+
+func newTargetCache() *targetCache {
+	return &targetCache{
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		inflight: make(map[string]*buildCall),
+	}
+}
+
+// getOrCreate returns the cached exporter for key, building it with build if
+// it isn't already cached. Concurrent callers racing to build the same key
+// share the in-flight build instead of each constructing (and leaking) a
+// separate exporter.
+func (c *targetCache) getOrCreate(key string, build func() (*exporter.Exporter, *prometheus.Registry, error)) (*probeTarget, error) {
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		c.mu.Unlock()
+		return elem.Value.(*probeTarget), nil
+	}
+	if call, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		call.wg.Wait()
+		return call.pt, call.err
+	}
+
+	call := &buildCall{}
+	call.wg.Add(1)
+	c.inflight[key] = call
+	c.mu.Unlock()
+
+	e, r, err := build()
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+
+	if err != nil {
+		c.mu.Unlock()
+		call.err = err
+		call.wg.Done()
+		return nil, err
+	}
+
+	pt := &probeTarget{key: key, exporter: e, registry: r}
+	elem := c.order.PushFront(pt)
+	c.entries[key] = elem
+
+	var evicted *probeTarget
+	if c.order.Len() > maxProbeTargets {
+		if oldest := c.order.Back(); oldest != nil {
+			c.order.Remove(oldest)
+			evicted = oldest.Value.(*probeTarget)
+			delete(c.entries, evicted.key)
+		}
+	}
+	c.mu.Unlock()
+
+	if evicted != nil {
+		evicted.exporter.Close()
+	}
+
+	call.pt = pt
+	call.wg.Done()
+	return pt, nil
+}
+
+// targetCacheKey derives a stable cache key from the target URL, the mTLS
+// material that will be used to reach it and the module's category and
+// unreported-node overrides, so two modules pointed at the same URL with
+// different settings don't collide.
+func targetCacheKey(target string, m config.Module) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%s|%s|%s|%t|%s|%s", target, m.CertFile, m.KeyFile, m.CACertFile, m.SSLSkipVerify, m.Categories, m.UnreportedNode)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// newProbeHandler returns an HTTP handler implementing the blackbox-exporter
+// style /probe endpoint: each request builds (or reuses) an Exporter for the
+// requested target/module and serves a single Collect through its own
+// registry.
+func newProbeHandler(c Config, modules map[string]config.Module, queries []customqueries.QueryConfig, cache *targetCache, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+
+		moduleName := r.URL.Query().Get("module")
+		if moduleName == "" {
+			moduleName = "default"
+		}
+
+		module, ok := modules[moduleName]
+		if !ok && moduleName != "default" {
+			http.Error(w, fmt.Sprintf("unknown module %q", moduleName), http.StatusBadRequest)
+			return
+		}
+
+		key := targetCacheKey(target, module)
+		pt, err := cache.getOrCreate(key, func() (*exporter.Exporter, *prometheus.Registry, error) {
+			return buildProbeExporter(c, target, module, queries, logger)
+		})
+		if err != nil {
+			logger.Error("failed to initialize exporter for probe target", "target", target, "error", err)
+			http.Error(w, fmt.Sprintf("failed to initialize exporter: %s", err), http.StatusInternalServerError)
+			return
+		}
+
+		promhttp.HandlerFor(pt.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	}
+}
+
+// buildProbeExporter constructs a fresh Exporter and Registry for a single
+// probe target, applying module overrides on top of the process-wide
+// defaults. Custom queries and fact names are process-wide and are shared
+// with every probed target, the same way they apply to the legacy /metrics
+// endpoint.
+func buildProbeExporter(c Config, target string, m config.Module, queries []customqueries.QueryConfig, logger *slog.Logger) (*exporter.Exporter, *prometheus.Registry, error) {
+	categories := c.Categories
+	if m.Categories != "" {
+		categories = m.Categories
+	}
+
+	unreportedNode := c.UnreportedNode
+	if m.UnreportedNode != "" {
+		unreportedNode = m.UnreportedNode
+	}
+	unreportedDuration, err := time.ParseDuration(unreportedNode)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse unreported duration: %s", err)
+	}
+
+	reportCacheTTL, err := time.ParseDuration(c.ReportCacheTTL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse report cache TTL: %s", err)
+	}
+
+	registry := prometheus.NewPedanticRegistry()
+	e, err := exporter.NewPuppetDBExporter(&exporter.Config{
+		URL:                target,
+		CertPath:           m.CertFile,
+		CACertPath:         m.CACertFile,
+		KeyPath:            m.KeyFile,
+		SSLVerify:          !m.SSLSkipVerify,
+		Categories:         parseCategories(categories),
+		UnreportedDuration: unreportedDuration,
+		Workers:            c.Workers,
+		ReportCacheTTL:     reportCacheTTL,
+		Queries:            queries,
+		FactLabels:         parseFacts(c.Facts),
+		FactGauges:         parseFacts(c.FactGauges),
+		Logger:             logger,
+	}, registry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return e, registry, nil
+}